@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Built-in GPU alerts, posted directly to Alertmanager's v2 API for hosts
+// without a Prometheus server. The alerter is a sink, so it sees every
+// sample and checks its rules once per interval.
+
+var (
+	alertmanagerURLs    = flag.String("alertmanager-url", "", "comma separated Alertmanager URLs to send alerts to, e.g. http://alertmanager:9093; disabled if empty")
+	alertResendInterval = flag.Duration("alert-resend-interval", time.Minute, "how often to re-send firing alerts to Alertmanager")
+	alertTemperature    = flag.Float64("alert-temperature", 90, "GPU temperature in celsius at or above which to fire NvidiaGPUOverTemperature")
+	alertStaleAfter     = flag.Duration("alert-stale-after", 0, "how long without output before a GPU is lost or nvidia-smi is stale (default 3 * interval)")
+	alertXid            = flag.Bool("alert-xid", true, "watch the kernel log (/dev/kmsg) for Xid errors to fire NvidiaGPUXid; needs root or CAP_SYSLOG")
+	alertXidDuration    = flag.Duration("alert-xid-duration", 10*time.Minute, "how long NvidiaGPUXid keeps firing after the last Xid error")
+
+	alertmanagerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nvidia_alertmanager_errors_total",
+		Help: "Failed attempts to send alerts to an Alertmanager",
+	}, []string{"alertmanager"})
+
+	// Xid errors that usually mean the GPU needs a reset or the host a
+	// reboot, rather than an application bug.
+	criticalXids = map[int]bool{
+		48:  true, // double-bit ECC error
+		62:  true, // internal micro-controller halt
+		63:  true, // ECC page retirement or row remapping pending
+		64:  true, // ECC page retirement or row remapping failure
+		74:  true, // NVLink error
+		79:  true, // GPU has fallen off the bus
+		94:  true, // contained ECC error
+		95:  true, // uncontained ECC error
+		119: true, // GSP RPC timeout
+		120: true, // GSP error
+	}
+)
+
+type alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+type alertGPU struct {
+	uuid        string
+	model       string
+	pciBusID    string // as normalized by normalizePCIBusID
+	lastSeen    time.Time
+	temperature float64
+	eccErrors   float64 // NaN if the GPU does not support ECC
+}
+
+type alerter struct {
+	urls         []string
+	host         string
+	generatorURL string
+	tempIndex    int
+	eccIndex     int
+
+	mu       sync.Mutex
+	gpus     map[string]*alertGPU
+	lastSeen time.Time
+
+	// latest Xid error by PCI bus id and Xid code
+	xids map[string]xidEvent
+
+	// firing alerts by alertname and gpu
+	firing   map[string]*alert
+	lastSent time.Time
+}
+
+// startAlerter sets up an alerter for the configured Alertmanagers and
+// starts checking its rules.
+func startAlerter() *alerter {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("error getting hostname: %s", err)
+	}
+	if *alertStaleAfter == 0 {
+		*alertStaleAfter = 3 * *interval
+	}
+
+	a := newAlerter(host, *alertmanagerURLs, time.Now())
+	prometheus.MustRegister(alertmanagerErrors)
+	if *alertXid {
+		if err := watchXids(a.writeXid); err != nil {
+			log.Printf("error watching %s for Xid errors, NvidiaGPUXid will not fire: %s (disable with -alert-xid=false)", kmsgPath, err)
+		}
+	}
+	go a.run()
+	log.Printf("Sending alerts to %s", strings.Join(a.urls, ", "))
+	return a
+}
+
+// newAlerter returns an alerter for host sending to the comma separated
+// Alertmanager urls, treating nvidia-smi as last seen at now.
+func newAlerter(host, urls string, now time.Time) *alerter {
+	a := &alerter{
+		host:         host,
+		generatorURL: fmt.Sprintf("http://%s:%d/metrics", host, *port),
+		tempIndex:    -1,
+		eccIndex:     -1,
+		gpus:         map[string]*alertGPU{},
+		lastSeen:     now,
+		xids:         map[string]xidEvent{},
+		firing:       map[string]*alert{},
+	}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			a.urls = append(a.urls, strings.TrimRight(u, "/")+"/api/v2/alerts")
+		}
+	}
+	for i, stat := range stats {
+		switch stat.name {
+		case "temperature.gpu":
+			a.tempIndex = i
+		case "ecc.errors.uncorrected.volatile.total":
+			a.eccIndex = i
+		}
+	}
+	return a
+}
+
+func (a *alerter) write(sample gpuSample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	g, ok := a.gpus[sample.gpu]
+	if !ok {
+		g = &alertGPU{}
+		a.gpus[sample.gpu] = g
+	}
+	g.uuid = sample.uuid
+	g.model = sample.model
+	g.pciBusID = normalizePCIBusID(sample.pciBusID)
+	g.lastSeen = sample.time
+	if a.tempIndex >= 0 {
+		g.temperature = sample.values[a.tempIndex]
+	}
+	g.eccErrors = math.NaN()
+	if a.eccIndex >= 0 {
+		g.eccErrors = sample.values[a.eccIndex]
+	}
+	a.lastSeen = sample.time
+}
+
+func (a *alerter) writeXid(x xidEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.xids[fmt.Sprintf("%s/%d", x.pciBusID, x.code)] = x
+}
+
+func (a *alerter) run() {
+	for {
+		time.Sleep(*interval)
+		a.tick(time.Now())
+	}
+}
+
+// tick evaluates the rules and sends alerts if any changed, or if firing
+// alerts are due to be re-sent.
+func (a *alerter) tick(now time.Time) {
+	changed := a.evaluate(now)
+	if changed != nil || now.Sub(a.lastSent) >= *alertResendInterval {
+		a.send(now, changed)
+		a.lastSent = now
+	}
+}
+
+// evaluate checks every rule, updating the firing alerts. It returns the
+// alerts that have started or resolved since the last evaluation.
+func (a *alerter) evaluate(now time.Time) []*alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	active := map[string]*alert{}
+	if stale := now.Sub(a.lastSeen); stale > *alertStaleAfter {
+		active["NvidiaSmiStale"] = a.newAlert("NvidiaSmiStale", "critical", nil, "",
+			"nvidia-smi has stopped reporting",
+			fmt.Sprintf("No output from nvidia-smi on %s for %s.", a.host, stale.Round(time.Second)))
+	} else {
+		// Only look at individual GPUs while nvidia-smi is working,
+		// otherwise every GPU would look lost.
+		for gpu, g := range a.gpus {
+			if lost := now.Sub(g.lastSeen); lost > *alertStaleAfter {
+				active["NvidiaGPULost/"+gpu] = a.newAlert("NvidiaGPULost", "critical", g, gpu,
+					"GPU has stopped reporting",
+					fmt.Sprintf("GPU %s on %s has not been reported by nvidia-smi for %s.", gpu, a.host, lost.Round(time.Second)))
+				continue
+			}
+			if a.tempIndex >= 0 && g.temperature >= *alertTemperature {
+				active["NvidiaGPUOverTemperature/"+gpu] = a.newAlert("NvidiaGPUOverTemperature", "warning", g, gpu,
+					"GPU is running hot",
+					fmt.Sprintf("GPU %s on %s is at %.0fC, at or above %.0fC.", gpu, a.host, g.temperature, *alertTemperature))
+			}
+			// The volatile count only resets when the driver is
+			// reloaded, which is also what recovers the GPU.
+			if g.eccErrors > 0 {
+				active["NvidiaGPUECCDoubleBit/"+gpu] = a.newAlert("NvidiaGPUECCDoubleBit", "critical", g, gpu,
+					"GPU has uncorrectable ECC errors",
+					fmt.Sprintf("GPU %s on %s has had %.0f uncorrected (double-bit) ECC errors since the driver was loaded.", gpu, a.host, g.eccErrors))
+			}
+		}
+	}
+
+	// Xids are checked even when nvidia-smi is stale, since an Xid is
+	// often why it stopped.
+	for key, x := range a.xids {
+		if now.Sub(x.time) > *alertXidDuration {
+			delete(a.xids, key)
+			continue
+		}
+		active["NvidiaGPUXid/"+key] = a.newXidAlert(x)
+	}
+
+	var changed []*alert
+	for key, al := range active {
+		if existing, ok := a.firing[key]; ok {
+			// keep the original start, but pick up new details
+			al.StartsAt = existing.StartsAt
+		} else {
+			al.StartsAt = now
+			changed = append(changed, al)
+		}
+		a.firing[key] = al
+	}
+	for key, al := range a.firing {
+		if _, ok := active[key]; !ok {
+			al.EndsAt = now
+			changed = append(changed, al)
+			delete(a.firing, key)
+		}
+	}
+	return changed
+}
+
+func (a *alerter) newAlert(name, severity string, g *alertGPU, gpu, summary, description string) *alert {
+	labels := map[string]string{
+		"alertname": name,
+		"severity":  severity,
+		"instance":  a.host,
+		"job":       "nvidia_exporter",
+	}
+	if g != nil {
+		labels["gpu"] = gpu
+		labels["uuid"] = g.uuid
+		labels["model"] = g.model
+	}
+	return &alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     summary,
+			"description": description,
+		},
+		GeneratorURL: a.generatorURL,
+	}
+}
+
+// newXidAlert returns the alert for an Xid error, with the GPU's labels if
+// it is one nvidia-smi has reported.
+func (a *alerter) newXidAlert(x xidEvent) *alert {
+	severity := "warning"
+	if criticalXids[x.code] {
+		severity = "critical"
+	}
+	name := "GPU at " + x.pciBusID
+	var gpuLabels *alertGPU
+	var gpuIndex string
+	for gpu, g := range a.gpus {
+		if g.pciBusID == x.pciBusID {
+			name, gpuLabels, gpuIndex = "GPU "+gpu, g, gpu
+			break
+		}
+	}
+
+	al := a.newAlert("NvidiaGPUXid", severity, gpuLabels, gpuIndex,
+		fmt.Sprintf("GPU reported Xid %d", x.code),
+		fmt.Sprintf("%s on %s reported Xid %d at %s: %s", name, a.host, x.code, x.time.Format(time.RFC3339), x.message))
+	al.Labels["xid"] = strconv.Itoa(x.code)
+	al.Labels["pci_bus_id"] = x.pciBusID
+	return al
+}
+
+// send posts every firing alert, plus any just resolved, to each
+// Alertmanager. Firing alerts get an endsAt far enough ahead that they
+// survive a missed re-send but still resolve if the exporter goes away.
+func (a *alerter) send(now time.Time, changed []*alert) {
+	a.mu.Lock()
+	alerts := []alert{}
+	for _, al := range a.firing {
+		copied := *al
+		copied.EndsAt = now.Add(4 * *alertResendInterval)
+		alerts = append(alerts, copied)
+	}
+	a.mu.Unlock()
+	for _, al := range changed {
+		if !al.EndsAt.IsZero() {
+			alerts = append(alerts, *al)
+		}
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		log.Printf("error encoding alerts: %s", err)
+		return
+	}
+	for _, u := range a.urls {
+		req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("error sending alerts to %s: %s", u, err)
+			alertmanagerErrors.WithLabelValues(u).Inc()
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if _, err := sendHTTP(req); err != nil {
+			log.Printf("error sending alerts to %s: %s", u, err)
+			alertmanagerErrors.WithLabelValues(u).Inc()
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// alertmanagerStandIn records the alerts posted to /api/v2/alerts.
+type alertmanagerStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests [][]alert
+}
+
+func newAlertmanagerStandIn(t *testing.T, status int) *alertmanagerStandIn {
+	am := &alertmanagerStandIn{}
+	am.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("got %s %s, want POST /api/v2/alerts", r.Method, r.URL.Path)
+		}
+		var alerts []alert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			t.Errorf("decoding alerts: %s", err)
+		}
+		am.mu.Lock()
+		am.requests = append(am.requests, alerts)
+		am.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(am.Close)
+	return am
+}
+
+func (am *alertmanagerStandIn) received() [][]alert {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return append([][]alert{}, am.requests...)
+}
+
+func newTestAlerter(t *testing.T, start time.Time, urls ...string) *alerter {
+	staleAfter := *alertStaleAfter
+	t.Cleanup(func() { *alertStaleAfter = staleAfter })
+	*alertStaleAfter = 15 * time.Second
+
+	return newAlerter("gpu-host", strings.Join(urls, ","), start)
+}
+
+func writeTestSample(a *alerter, now time.Time, gpu string, temperature, eccErrors float64) {
+	values := make([]float64, len(stats))
+	values[a.tempIndex] = temperature
+	values[a.eccIndex] = eccErrors
+	a.write(gpuSample{
+		time:     now,
+		gpu:      gpu,
+		uuid:     "GPU-" + gpu,
+		model:    "NVIDIA H100",
+		pciBusID: "00000000:1" + gpu + ":00.0",
+		values:   values,
+	})
+}
+
+func TestAlertBody(t *testing.T) {
+	am := newAlertmanagerStandIn(t, http.StatusOK)
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	a := newTestAlerter(t, t0, am.URL)
+
+	writeTestSample(a, t0, "0", 95, 0)
+	a.tick(t0)
+
+	requests := am.received()
+	if len(requests) != 1 || len(requests[0]) != 1 {
+		t.Fatalf("got %v, want one request with one alert", requests)
+	}
+	al := requests[0][0]
+
+	wantLabels := map[string]string{
+		"alertname": "NvidiaGPUOverTemperature",
+		"severity":  "warning",
+		"instance":  "gpu-host",
+		"job":       "nvidia_exporter",
+		"gpu":       "0",
+		"uuid":      "GPU-0",
+		"model":     "NVIDIA H100",
+	}
+	for k, v := range wantLabels {
+		if al.Labels[k] != v {
+			t.Errorf("label %s: got %q, want %q", k, al.Labels[k], v)
+		}
+	}
+	if al.Annotations["summary"] == "" || al.Annotations["description"] == "" {
+		t.Errorf("missing annotations: %v", al.Annotations)
+	}
+	if !al.StartsAt.Equal(t0) {
+		t.Errorf("got startsAt %s, want %s", al.StartsAt, t0)
+	}
+	if want := t0.Add(4 * *alertResendInterval); !al.EndsAt.Equal(want) {
+		t.Errorf("got endsAt %s, want %s", al.EndsAt, want)
+	}
+	if al.GeneratorURL != "http://gpu-host:9523/metrics" {
+		t.Errorf("got generatorURL %q", al.GeneratorURL)
+	}
+}
+
+func TestAlertResolvedOnce(t *testing.T) {
+	am := newAlertmanagerStandIn(t, http.StatusOK)
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	a := newTestAlerter(t, t0, am.URL)
+
+	writeTestSample(a, t0, "0", 95, 0)
+	a.tick(t0)
+
+	t1 := t0.Add(5 * time.Second)
+	writeTestSample(a, t1, "0", 50, 0)
+	a.tick(t1)
+
+	requests := am.received()
+	if len(requests) != 2 || len(requests[1]) != 1 {
+		t.Fatalf("got %v, want a second request with the resolved alert", requests)
+	}
+	if al := requests[1][0]; !al.EndsAt.Equal(t1) || !al.StartsAt.Equal(t0) {
+		t.Errorf("got startsAt %s endsAt %s, want %s and %s", al.StartsAt, al.EndsAt, t0, t1)
+	}
+
+	// nothing is firing, so nothing more is sent, even once a re-send
+	// would be due
+	for _, now := range []time.Time{t1.Add(5 * time.Second), t1.Add(2 * *alertResendInterval)} {
+		writeTestSample(a, now, "0", 50, 0)
+		a.tick(now)
+	}
+	if n := len(am.received()); n != 2 {
+		t.Errorf("got %d requests, want the resolved alert sent only once", n)
+	}
+}
+
+func TestAlertResend(t *testing.T) {
+	am := newAlertmanagerStandIn(t, http.StatusOK)
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	a := newTestAlerter(t, t0, am.URL)
+
+	for _, offset := range []time.Duration{0, 10 * time.Second, *alertResendInterval + time.Second} {
+		now := t0.Add(offset)
+		writeTestSample(a, now, "0", 95, 0)
+		a.tick(now)
+	}
+
+	requests := am.received()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want the initial send and one re-send", len(requests))
+	}
+	resent := requests[1][0]
+	if !resent.StartsAt.Equal(t0) {
+		t.Errorf("re-sent alert has startsAt %s, want the original %s", resent.StartsAt, t0)
+	}
+	if want := t0.Add(*alertResendInterval + time.Second).Add(4 * *alertResendInterval); !resent.EndsAt.Equal(want) {
+		t.Errorf("re-sent alert has endsAt %s, want %s", resent.EndsAt, want)
+	}
+}
+
+func TestAlertFanOut(t *testing.T) {
+	good := newAlertmanagerStandIn(t, http.StatusOK)
+	bad := newAlertmanagerStandIn(t, http.StatusInternalServerError)
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	a := newTestAlerter(t, t0, good.URL, bad.URL)
+
+	writeTestSample(a, t0, "0", 95, 0)
+	a.tick(t0)
+
+	if len(good.received()) != 1 || len(bad.received()) != 1 {
+		t.Errorf("got %d and %d requests, want one to each Alertmanager", len(good.received()), len(bad.received()))
+	}
+	if got := testutil.ToFloat64(alertmanagerErrors.WithLabelValues(a.urls[0])); got != 0 {
+		t.Errorf("counted %v errors for the working Alertmanager, want 0", got)
+	}
+	if got := testutil.ToFloat64(alertmanagerErrors.WithLabelValues(a.urls[1])); got != 1 {
+		t.Errorf("counted %v errors for the failing Alertmanager, want 1", got)
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	a := newTestAlerter(t, t0)
+
+	firing := func() map[string]bool {
+		names := map[string]bool{}
+		for key := range a.firing {
+			names[key] = true
+		}
+		return names
+	}
+
+	writeTestSample(a, t0, "0", 50, 0)
+	writeTestSample(a, t0, "1", 50, math.NaN())
+	a.evaluate(t0)
+	if len(a.firing) != 0 {
+		t.Errorf("healthy GPUs fired %v", firing())
+	}
+
+	// GPU 1 stops reporting while GPU 0 has double-bit errors
+	t1 := t0.Add(20 * time.Second)
+	writeTestSample(a, t1, "0", 50, 2)
+	a.evaluate(t1)
+	if got := firing(); len(got) != 2 || !got["NvidiaGPULost/1"] || !got["NvidiaGPUECCDoubleBit/0"] {
+		t.Errorf("got %v, want NvidiaGPULost for GPU 1 and NvidiaGPUECCDoubleBit for GPU 0", got)
+	}
+
+	// then nvidia-smi stops reporting anything
+	a.evaluate(t1.Add(20 * time.Second))
+	if got := firing(); len(got) != 1 || !got["NvidiaSmiStale"] {
+		t.Errorf("got %v, want only NvidiaSmiStale", got)
+	}
+}
+
+func TestAlertXid(t *testing.T) {
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	a := newTestAlerter(t, t0)
+
+	writeTestSample(a, t0, "1", 50, 0)
+	for _, record := range []string{
+		"3,1234,5678901,-;NVRM: Xid (PCI:0000:11:00): 79, pid=0, GPU has fallen off the bus.",
+		"4,1235,5678902,-;NVRM: Xid (PCI:0000:3b:00): 13, pid=4321, Graphics Exception",
+	} {
+		x, ok := parseXid(record, t0)
+		if !ok {
+			t.Fatalf("parseXid(%q) failed", record)
+		}
+		a.writeXid(x)
+	}
+	a.evaluate(t0)
+
+	known := a.firing["NvidiaGPUXid/0000:11:00/79"]
+	if known == nil {
+		t.Fatalf("got %v, want NvidiaGPUXid for GPU 1", a.firing)
+	}
+	wantLabels := map[string]string{"gpu": "1", "uuid": "GPU-1", "xid": "79", "pci_bus_id": "0000:11:00", "severity": "critical"}
+	for k, v := range wantLabels {
+		if known.Labels[k] != v {
+			t.Errorf("label %s: got %q, want %q", k, known.Labels[k], v)
+		}
+	}
+
+	// an Xid from a GPU nvidia-smi has not reported still fires
+	unknown := a.firing["NvidiaGPUXid/0000:3b:00/13"]
+	if unknown == nil {
+		t.Fatalf("got %v, want NvidiaGPUXid for 0000:3b:00", a.firing)
+	}
+	if unknown.Labels["gpu"] != "" || unknown.Labels["severity"] != "warning" {
+		t.Errorf("unexpected labels %v", unknown.Labels)
+	}
+
+	// Xids keep firing while nvidia-smi is stale, then resolve
+	a.evaluate(t0.Add(*alertXidDuration - time.Second))
+	if len(a.firing) != 3 || a.firing["NvidiaSmiStale"] == nil {
+		t.Errorf("got %v, want both Xids and NvidiaSmiStale", a.firing)
+	}
+	end := t0.Add(*alertXidDuration + time.Second)
+	writeTestSample(a, end, "1", 50, 0)
+	changed := a.evaluate(end)
+	if len(a.firing) != 0 || len(changed) != 3 {
+		t.Errorf("got %v firing and %d changed, want all resolved", a.firing, len(changed))
+	}
+}
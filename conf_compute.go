@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Confidential computing state, read from `nvidia-smi conf-compute` and the
+// protected memory section of `nvidia-smi -q -x`. The CC and dev-tools
+// modes and the ready state apply to the whole system, so they are
+// reported for every GPU.
+
+var (
+	confCompute         = flag.Bool("conf-compute", false, "collect confidential computing metrics (needs a driver with nvidia-smi conf-compute)")
+	confComputeInterval = flag.Duration("conf-compute-interval", time.Minute, "how often to collect confidential computing metrics")
+
+	ccMode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_cc_mode_enabled",
+		Help: "Whether confidential computing mode is on",
+	}, []string{"gpu"})
+	ccDevToolsMode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_cc_devtools_mode_enabled",
+		Help: "Whether confidential computing dev-tools mode is on",
+	}, []string{"gpu"})
+	ccReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_cc_ready",
+		Help: "Whether the GPU is in the confidential computing ready state and accepting work",
+	}, []string{"gpu"})
+	ccProtectedMemoryTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_cc_protected_memory_total_megabytes",
+		Help: "Total GPU memory in the confidential computing protected region",
+	}, []string{"gpu"})
+	ccProtectedMemoryUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nvidia_cc_protected_memory_used_megabytes",
+		Help: "Used GPU memory in the confidential computing protected region",
+	}, []string{"gpu"})
+)
+
+// ccState is the system-wide state reported by nvidia-smi conf-compute.
+// A nil field was not present in the output.
+type ccState struct {
+	mode     *float64
+	devTools *float64
+	ready    *float64
+}
+
+// ccXMLLog is the part of `nvidia-smi -q -x` holding protected memory
+// usage.
+type ccXMLLog struct {
+	GPUs []struct {
+		UUID            string `xml:"uuid"`
+		ProtectedMemory struct {
+			Total string `xml:"total"`
+			Used  string `xml:"used"`
+		} `xml:"cc_protected_memory_usage"`
+	} `xml:"gpu"`
+}
+
+func scrapeConfCompute() {
+	prometheus.MustRegister(ccMode, ccDevToolsMode, ccReady, ccProtectedMemoryTotal, ccProtectedMemoryUsed)
+
+	for {
+		collectConfCompute()
+		time.Sleep(*confComputeInterval)
+	}
+}
+
+func collectConfCompute() {
+	// -q reports everything on recent drivers, including dev-tools mode,
+	// which older ones only report as a CC mode from -f.
+	state := ccState{}
+	for _, args := range [][]string{{"conf-compute", "-f"}, {"conf-compute", "-grs"}, {"conf-compute", "-q"}} {
+		out, err := runSmi(args...)
+		if err != nil {
+			log.Printf("error running nvidia-smi %s: %s", strings.Join(args, " "), firstLine(out, err))
+			continue
+		}
+		parseConfCompute(out, &state)
+	}
+
+	var gpus map[string]string
+	out, err := runSmi("--query-gpu=index,uuid", "--format=csv,noheader")
+	if err != nil {
+		log.Printf("error listing GPUs: %s", firstLine(out, err))
+	} else {
+		gpus = parseGPUIndices(out)
+	}
+
+	var memory []ccMemory
+	if out, err := runSmi("-q", "-x"); err != nil {
+		log.Printf("error running nvidia-smi -q -x: %s", firstLine(out, err))
+	} else if memory, err = parseProtectedMemory(out); err != nil {
+		log.Printf("error parsing nvidia-smi XML output: %s", err)
+	}
+
+	setConfCompute(state, gpus, memory)
+}
+
+// setConfCompute replaces the values of the CC gauges with those from one
+// collection. Anything not reported this time is removed rather than left
+// at its last value, so a GPU is never shown as ready when nvidia-smi can
+// no longer say.
+func setConfCompute(state ccState, gpus map[string]string, memory []ccMemory) {
+	for _, g := range []*prometheus.GaugeVec{ccMode, ccDevToolsMode, ccReady, ccProtectedMemoryTotal, ccProtectedMemoryUsed} {
+		g.Reset()
+	}
+
+	for _, gpu := range gpus {
+		labels := prometheus.Labels{"gpu": gpu}
+		setIfPresent(ccMode, labels, state.mode)
+		setIfPresent(ccDevToolsMode, labels, state.devTools)
+		setIfPresent(ccReady, labels, state.ready)
+	}
+	for _, m := range memory {
+		gpu, ok := gpus[m.uuid]
+		if !ok {
+			continue
+		}
+		labels := prometheus.Labels{"gpu": gpu}
+		setIfPresent(ccProtectedMemoryTotal, labels, m.total)
+		setIfPresent(ccProtectedMemoryUsed, labels, m.used)
+	}
+}
+
+// parseGPUIndices maps GPU uuids to indices from `nvidia-smi
+// --query-gpu=index,uuid --format=csv,noheader` output.
+func parseGPUIndices(out string) map[string]string {
+	gpus := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		data := strings.Split(line, ", ")
+		if len(data) != 2 {
+			continue
+		}
+		gpus[strings.TrimSpace(data[1])] = strings.TrimSpace(data[0])
+	}
+	return gpus
+}
+
+// ccMemory is the protected memory of one GPU in megabytes; nil if N/A.
+type ccMemory struct {
+	uuid  string
+	total *float64
+	used  *float64
+}
+
+// parseProtectedMemory reads the protected memory of each GPU from
+// `nvidia-smi -q -x` output.
+func parseProtectedMemory(out string) ([]ccMemory, error) {
+	var smiLog ccXMLLog
+	if err := xml.Unmarshal([]byte(out), &smiLog); err != nil {
+		return nil, err
+	}
+
+	var memory []ccMemory
+	for _, g := range smiLog.GPUs {
+		memory = append(memory, ccMemory{
+			uuid:  strings.TrimSpace(g.UUID),
+			total: parseMiB(g.ProtectedMemory.Total),
+			used:  parseMiB(g.ProtectedMemory.Used),
+		})
+	}
+	return memory, nil
+}
+
+// parseConfCompute reads the "key: value" lines printed by nvidia-smi
+// conf-compute, e.g. "CC status: ON", "DevTools Mode: OFF" or
+// "Confidential Compute GPUs Ready state: ready".
+func parseConfCompute(out string, state *ccState) {
+	on, off := 1.0, 0.0
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.ToLower(strings.TrimSpace(parts[1]))
+
+		switch {
+		case strings.Contains(key, "devtools"), strings.Contains(key, "dev tools"):
+			if value == "on" {
+				state.devTools = &on
+			} else {
+				state.devTools = &off
+			}
+		case strings.Contains(key, "ready state"):
+			if value == "ready" {
+				state.ready = &on
+			} else {
+				state.ready = &off
+			}
+		case strings.HasPrefix(key, "cc status"), strings.HasPrefix(key, "cc state"), strings.HasPrefix(key, "cc mode"):
+			// Some drivers report dev-tools as a third CC mode
+			// rather than on a line of its own.
+			switch value {
+			case "on":
+				state.mode = &on
+			case "devtools":
+				state.mode = &on
+				state.devTools = &on
+			default:
+				state.mode = &off
+			}
+		}
+	}
+}
+
+// parseMiB parses a memory size such as "1024 MiB" as printed in the XML
+// output, returning nil for N/A or missing values.
+func parseMiB(s string) *float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "MiB")), 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+func setIfPresent(g *prometheus.GaugeVec, labels prometheus.Labels, value *float64) {
+	if value != nil {
+		g.With(labels).Set(*value)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func readFixture(t *testing.T, name string) string {
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "conf_compute", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func checkValue(t *testing.T, name string, got *float64, want float64) {
+	t.Helper()
+	if got == nil {
+		t.Errorf("%s: not set, want %v", name, want)
+	} else if *got != want {
+		t.Errorf("%s: got %v, want %v", name, *got, want)
+	}
+}
+
+func TestParseConfCompute(t *testing.T) {
+	tests := []struct {
+		fixtures []string
+		mode     float64
+		devTools *float64 // nil if not reported
+		ready    float64
+	}{
+		{[]string{"f_on.txt", "grs_ready.txt"}, 1, nil, 1},
+		{[]string{"f_off.txt", "grs_not_ready.txt"}, 0, nil, 0},
+		{[]string{"f_devtools.txt", "grs_ready.txt"}, 1, ptr(1), 1},
+		{[]string{"f_on.txt", "grs_ready.txt", "q_devtools.txt"}, 1, ptr(1), 0},
+	}
+
+	for _, tt := range tests {
+		state := ccState{}
+		for _, f := range tt.fixtures {
+			parseConfCompute(readFixture(t, f), &state)
+		}
+
+		checkValue(t, "mode", state.mode, tt.mode)
+		checkValue(t, "ready", state.ready, tt.ready)
+		if tt.devTools == nil {
+			if state.devTools != nil {
+				t.Errorf("%v: devTools set to %v, want unset", tt.fixtures, *state.devTools)
+			}
+		} else {
+			checkValue(t, "devTools", state.devTools, *tt.devTools)
+		}
+	}
+}
+
+func TestParseProtectedMemory(t *testing.T) {
+	memory, err := parseProtectedMemory(readFixture(t, "q_x.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memory) != 2 {
+		t.Fatalf("got %d GPUs, want 2", len(memory))
+	}
+
+	if memory[0].uuid != "GPU-aaaa-1111" || memory[1].uuid != "GPU-bbbb-2222" {
+		t.Errorf("got uuids %q and %q", memory[0].uuid, memory[1].uuid)
+	}
+	checkValue(t, "gpu 0 total", memory[0].total, 79872)
+	checkValue(t, "gpu 0 used", memory[0].used, 1024)
+	if memory[1].total != nil || memory[1].used != nil {
+		t.Errorf("gpu 1: got %v/%v, want N/A to be unset", memory[1].total, memory[1].used)
+	}
+
+	if _, err := parseProtectedMemory("not xml <"); err == nil {
+		t.Error("parsing invalid XML succeeded")
+	}
+}
+
+func TestParseMiB(t *testing.T) {
+	checkValue(t, "1024 MiB", parseMiB("1024 MiB"), 1024)
+	checkValue(t, " 0 MiB ", parseMiB(" 0 MiB "), 0)
+	for _, s := range []string{"N/A", "", "[N/A]"} {
+		if v := parseMiB(s); v != nil {
+			t.Errorf("parseMiB(%q) = %v, want nil", s, *v)
+		}
+	}
+}
+
+func TestParseGPUIndices(t *testing.T) {
+	gpus := parseGPUIndices("0, GPU-aaaa-1111\n1, GPU-bbbb-2222\n")
+	if len(gpus) != 2 || gpus["GPU-aaaa-1111"] != "0" || gpus["GPU-bbbb-2222"] != "1" {
+		t.Errorf("got %v", gpus)
+	}
+}
+
+func TestSetConfCompute(t *testing.T) {
+	memory, err := parseProtectedMemory(readFixture(t, "q_x.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// indices deliberately not in the order the XML lists the GPUs
+	gpus := map[string]string{"GPU-aaaa-1111": "1", "GPU-bbbb-2222": "0"}
+	setConfCompute(ccState{mode: ptr(1), ready: ptr(1)}, gpus, memory)
+
+	for _, gpu := range []string{"0", "1"} {
+		if got := testutil.ToFloat64(ccReady.WithLabelValues(gpu)); got != 1 {
+			t.Errorf("gpu %s: got ready %v, want 1", gpu, got)
+		}
+	}
+	if n := testutil.CollectAndCount(ccDevToolsMode); n != 0 {
+		t.Errorf("got %d dev-tools series, want none when not reported", n)
+	}
+	if n := testutil.CollectAndCount(ccProtectedMemoryTotal); n != 1 {
+		t.Errorf("got %d protected memory series, want 1", n)
+	}
+	if got := testutil.ToFloat64(ccProtectedMemoryTotal.WithLabelValues("1")); got != 79872 {
+		t.Errorf("got protected memory %v for gpu 1, want that of GPU-aaaa-1111", got)
+	}
+
+	// a later collection that cannot read the ready state or XML
+	setConfCompute(ccState{mode: ptr(1)}, gpus, nil)
+	if n := testutil.CollectAndCount(ccReady); n != 0 {
+		t.Errorf("got %d ready series after the ready state went missing, want none", n)
+	}
+	if n := testutil.CollectAndCount(ccProtectedMemoryUsed); n != 0 {
+		t.Errorf("got %d protected memory series after the XML went missing, want none", n)
+	}
+	if n := testutil.CollectAndCount(ccMode); n != 2 {
+		t.Errorf("got %d mode series, want 2", n)
+	}
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
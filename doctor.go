@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The doctor subcommand checks the environment the exporter runs in and
+// suggests fixes for anything that looks wrong.
+
+const (
+	kubeletSocket = "/var/lib/kubelet/pod-resources/kubelet.sock"
+	dockerSocket  = "/var/run/docker.sock"
+)
+
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkWarn
+	// checkFail means the exporter cannot work until the problem is fixed.
+	checkFail
+)
+
+func (s checkStatus) String() string {
+	switch s {
+	case checkOK:
+		return "OK"
+	case checkWarn:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+	hint   string
+}
+
+// doctor runs every check, prints the results and returns the exit code.
+func doctor() int {
+	var results []checkResult
+	add := func(name string, status checkStatus, detail, hint string) {
+		results = append(results, checkResult{name, status, detail, hint})
+	}
+
+	if path, err := exec.LookPath("nvidia-smi"); err != nil {
+		add("nvidia-smi", checkFail, "not found on PATH",
+			"install the NVIDIA driver utilities or add the directory containing nvidia-smi to PATH")
+	} else {
+		add("nvidia-smi", checkOK, fmt.Sprintf("%s, version %s", path, smiVersion()), "")
+		doctorDriver(add)
+		doctorFields(add)
+		doctorAccounting(add)
+	}
+
+	doctorProc(add)
+	doctorKmsg(add)
+	doctorCgroup(add)
+	doctorSocket(add, "kubelet socket", kubeletSocket,
+		"needed only under Kubernetes; mount the kubelet pod-resources directory into the container")
+	doctorSocket(add, "docker socket", dockerSocket,
+		"needed only with Docker; mount the socket and run as a user in the docker group")
+
+	if l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port)); err != nil {
+		add("listen port", checkFail, err.Error(),
+			"stop whatever is using the port or pick another one with -port")
+	} else {
+		l.Close()
+		add("listen port", checkOK, fmt.Sprintf(":%d is free", *port), "")
+	}
+
+	exitCode := 0
+	for _, r := range results {
+		fmt.Printf("[%-4s] %-16s %s\n", r.status, r.name, r.detail)
+		if r.status != checkOK && r.hint != "" {
+			fmt.Printf("       %-16s hint: %s\n", "", r.hint)
+		}
+		if r.status == checkFail {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+func smiVersion() string {
+	// Older releases do not support --version but do report the driver.
+	if out, err := runSmi("--version"); err == nil {
+		if version := parseSmiVersion(out); version != "" {
+			return version
+		}
+	}
+	if out, err := runSmi("--query-gpu=driver_version", "--format=csv,noheader"); err == nil {
+		return "unknown (driver " + strings.Split(out, "\n")[0] + ")"
+	}
+	return "unknown"
+}
+
+// parseSmiVersion finds the version in `nvidia-smi --version` output,
+// returning "" if there is none.
+func parseSmiVersion(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "NVIDIA-SMI version") {
+			if i := strings.Index(line, ":"); i >= 0 {
+				return strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return ""
+}
+
+func doctorDriver(add func(string, checkStatus, string, string)) {
+	out, err := runSmi("-L")
+	if err != nil {
+		add("driver", checkFail, fmt.Sprintf("nvidia-smi -L failed: %s", firstLine(out, err)),
+			"check the nvidia kernel module is loaded (lsmod | grep nvidia) and /dev/nvidia* is accessible")
+		return
+	}
+	gpus := 0
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			gpus++
+		}
+	}
+	if gpus == 0 {
+		add("driver", checkFail, "no GPUs found",
+			"check the GPUs are visible to this host or container (NVIDIA_VISIBLE_DEVICES)")
+		return
+	}
+	add("driver", checkOK, fmt.Sprintf("%d GPU(s) found", gpus), "")
+}
+
+// doctorFields checks every field the exporter queries returns a number,
+// since the exporter skips any GPU with a value it cannot parse.
+func doctorFields(add func(string, checkStatus, string, string)) {
+	var unsupported []string
+	for _, stat := range stats {
+		out, err := runSmi("--query-gpu="+stat.name, "--format=csv,noheader,nounits")
+		if err != nil {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", stat.name, firstLine(out, err)))
+			continue
+		}
+		for _, value := range strings.Split(out, "\n") {
+			value = strings.TrimSpace(value)
+			if stat.optional && notSupported(value) {
+				continue
+			}
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				unsupported = append(unsupported, fmt.Sprintf("%s (%s)", stat.name, value))
+				break
+			}
+		}
+	}
+	if unsupported != nil {
+		add("fields", checkFail, "unsupported: "+strings.Join(unsupported, ", "),
+			"these GPUs or this driver do not report every field; upgrade the driver")
+		return
+	}
+	add("fields", checkOK, fmt.Sprintf("all %d fields supported", len(stats)), "")
+}
+
+func doctorAccounting(add func(string, checkStatus, string, string)) {
+	out, err := runSmi("--query-accounted-apps=pid", "--format=csv,noheader")
+	switch {
+	case err == nil:
+		add("accounting", checkOK, "accounted apps can be queried", "")
+	case strings.Contains(firstLine(out, err), "Insufficient Permissions"):
+		add("accounting", checkWarn, "insufficient permissions",
+			"run as root, or as root allow users to query accounting: nvidia-smi -acp 0")
+	default:
+		add("accounting", checkWarn, firstLine(out, err),
+			"enable accounting mode as root: nvidia-smi -am 1")
+	}
+}
+
+// doctorProc checks other processes can be inspected. Environments and
+// open files of processes owned by other users need root or
+// CAP_SYS_PTRACE, unlike most of /proc which is world-readable.
+func doctorProc(add func(string, checkStatus, string, string)) {
+	if _, err := ioutil.ReadDir("/proc"); err != nil {
+		add("process access", checkWarn, err.Error(), "mount /proc, or the host's /proc, into the container")
+		return
+	}
+	if _, err := ioutil.ReadDir("/proc/1/fd"); err != nil {
+		add("process access", checkWarn, fmt.Sprintf("cannot inspect PID 1: %s", err),
+			"run as root or with CAP_SYS_PTRACE to inspect processes owned by other users")
+		return
+	}
+	add("process access", checkOK, "other processes can be inspected", "")
+}
+
+func doctorKmsg(add func(string, checkStatus, string, string)) {
+	f, err := os.Open(kmsgPath)
+	if err != nil {
+		add("kernel log", checkWarn, err.Error(),
+			"needed only for the NvidiaGPUXid alert; run as root or with CAP_SYSLOG and /dev/kmsg available, or use -alert-xid=false")
+		return
+	}
+	f.Close()
+	add("kernel log", checkOK, kmsgPath+" is readable", "")
+}
+
+func doctorCgroup(add func(string, checkStatus, string, string)) {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		add("cgroup", checkOK, "cgroup v2", "")
+	} else if _, err := os.Stat("/sys/fs/cgroup/memory"); err == nil {
+		add("cgroup", checkOK, "cgroup v1", "")
+	} else {
+		add("cgroup", checkWarn, "no cgroup filesystem found at /sys/fs/cgroup",
+			"mount /sys/fs/cgroup read-only into the container")
+	}
+}
+
+func doctorSocket(add func(string, checkStatus, string, string), name, path, hint string) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		add(name, checkWarn, err.Error(), hint)
+		return
+	}
+	conn.Close()
+	add(name, checkOK, path+" is reachable", "")
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestParseSmiVersion(t *testing.T) {
+	for _, tt := range []struct {
+		out  string
+		want string
+	}{
+		{"NVIDIA-SMI version  : 535.104.05\nNVML version        : 535.104\nDRIVER version      : 535.104.05\nCUDA Version        : 12.2", "535.104.05"},
+		{"NVIDIA-SMI version:550.54.15", "550.54.15"},
+		{"DRIVER version      : 535.104.05", ""},
+		{"NVIDIA-SMI version", ""},
+		{"", ""},
+	} {
+		if got := parseSmiVersion(tt.out); got != tt.want {
+			t.Errorf("parseSmiVersion(%q) = %q, want %q", tt.out, got, tt.want)
+		}
+	}
+}
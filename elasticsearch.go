@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Elasticsearch / OpenSearch output using the _bulk API. Records go to
+// daily indices named <prefix>-<kind>s-YYYY.MM.DD.
+
+var (
+	esURL         = flag.String("es-url", "", "Elasticsearch or OpenSearch URL to send samples and setting changes to, e.g. http://localhost:9200; disabled if empty")
+	esUsername    = flag.String("es-username", "", "username for Elasticsearch basic auth")
+	esPassword    = flag.String("es-password", "", "password for Elasticsearch basic auth")
+	esIndexPrefix = flag.String("es-index-prefix", "nvidia-gpu", "prefix of the Elasticsearch indices to write to")
+	esTemplate    = flag.Bool("es-index-template", true, "install an index template for the Elasticsearch indices on startup")
+)
+
+type elasticsearchSink struct {
+	host    string
+	batcher *batcher
+}
+
+// esBulkResponse is the part of a _bulk response needed to find out which
+// documents were rejected.
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func newElasticsearchSink() *elasticsearchSink {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("error getting hostname: %s", err)
+	}
+
+	e := &elasticsearchSink{host: host}
+	if *esTemplate {
+		// The cluster may not be up yet; documents can still be indexed
+		// without the template, just with dynamic mappings.
+		if err := e.putTemplate(); err != nil {
+			log.Printf("error installing Elasticsearch index template: %s", err)
+		}
+	}
+	e.batcher = newBatcher("elasticsearch", e.post)
+	log.Printf("Sending samples and setting changes to Elasticsearch at %s", *esURL)
+	return e
+}
+
+func (e *elasticsearchSink) write(sample gpuSample) {
+	e.batcher.add(sampleRecord(sample))
+}
+
+func (e *elasticsearchSink) writeChange(c settingChange) {
+	e.batcher.add(changeRecord(c))
+}
+
+func (e *elasticsearchSink) newRequest(method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(*esURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if *esUsername != "" {
+		req.SetBasicAuth(*esUsername, *esPassword)
+	}
+	return req, nil
+}
+
+func (e *elasticsearchSink) putTemplate() error {
+	keyword := map[string]string{"type": "keyword"}
+	template := map[string]interface{}{
+		"index_patterns": []string{*esIndexPrefix + "-*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"@timestamp": map[string]string{"type": "date"},
+					"host":       keyword,
+					"kind":       keyword,
+					"gpu":        keyword,
+					"uuid":       keyword,
+					"model":      keyword,
+					"setting":    keyword,
+					"old":        keyword,
+					"new":        keyword,
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	req, err := e.newRequest("PUT", "/_index_template/"+*esIndexPrefix, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = sendHTTP(req)
+	return err
+}
+
+func (e *elasticsearchSink) index(r record) string {
+	return fmt.Sprintf("%s-%ss-%s", *esIndexPrefix, r.kind, r.time.UTC().Format("2006.01.02"))
+}
+
+// post sends a batch as a single _bulk request. Documents rejected with a
+// 429 are retried on their own; other rejected documents are dropped.
+func (e *elasticsearchSink) post(batch []record) ([]record, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range batch {
+		doc := map[string]interface{}{
+			"@timestamp": r.time.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+			"host":       e.host,
+			"kind":       r.kind,
+		}
+		for k, v := range r.fields {
+			doc[k] = v
+		}
+
+		action := map[string]interface{}{"index": map[string]string{"_index": e.index(r)}}
+		if err := enc.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := e.newRequest("POST", "/_bulk", body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	respBody, err := sendHTTP(req)
+	if err != nil {
+		if retryable(err) {
+			return batch, err
+		}
+		return nil, err
+	}
+
+	var resp esBulkResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, fmt.Errorf("error decoding _bulk response: %s", err)
+	}
+	if !resp.Errors {
+		return nil, nil
+	}
+
+	var retry []record
+	rejected := 0
+	for i, item := range resp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status == http.StatusTooManyRequests && i < len(batch):
+				retry = append(retry, batch[i])
+			case result.Status/100 != 2:
+				rejected++
+				if rejected == 1 {
+					log.Printf("elasticsearch: document rejected with status %d: %s", result.Status, result.Error)
+				}
+			}
+		}
+	}
+	if rejected > 0 {
+		sinkDropped.WithLabelValues("elasticsearch").Add(float64(rejected))
+	}
+	if retry != nil {
+		return retry, errors.New("some documents were rejected with HTTP 429")
+	}
+	return nil, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestElasticsearchBulkBody(t *testing.T) {
+	var path, contentType string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+		fmt.Fprint(w, `{"errors":false,"items":[]}`)
+	}))
+	defer srv.Close()
+	setFlag(t, esURL, srv.URL)
+
+	batch := []record{
+		{time: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC), kind: "sample", fields: map[string]interface{}{"gpu": "0", "power.draw": 300.5}},
+		{time: time.Date(2026, 10, 18, 1, 0, 0, 0, time.UTC), kind: "change", fields: map[string]interface{}{"gpu": "1", "setting": "power.limit"}},
+	}
+	e := &elasticsearchSink{host: "gpu-host"}
+	if _, err := e.post(batch); err != nil {
+		t.Fatalf("post: %s", err)
+	}
+
+	if path != "/_bulk" || contentType != "application/x-ndjson" {
+		t.Errorf("got POST %s (%s), want /_bulk (application/x-ndjson)", path, contentType)
+	}
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("decoding %q: %s", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want an action and document per record:\n%s", len(lines), body)
+	}
+
+	wantIndices := []string{"nvidia-gpu-samples-2026.10.17", "nvidia-gpu-changes-2026.10.18"}
+	for i, want := range wantIndices {
+		action, ok := lines[2*i]["index"].(map[string]interface{})
+		if !ok || action["_index"] != want {
+			t.Errorf("record %d: got action %v, want index into %s", i, lines[2*i], want)
+		}
+	}
+
+	doc := lines[1]
+	if doc["@timestamp"] != "2026-10-17T12:00:00.000Z" || doc["host"] != "gpu-host" ||
+		doc["kind"] != "sample" || doc["gpu"] != "0" || doc["power.draw"] != 300.5 {
+		t.Errorf("unexpected document: %v", doc)
+	}
+}
+
+func TestElasticsearchPartialRetry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errors":true,"items":[
+			{"index":{"status":201}},
+			{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},
+			{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},
+			{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}
+		]}`)
+	}))
+	defer srv.Close()
+	setFlag(t, esURL, srv.URL)
+
+	batch := testRecords(4)
+	for i := range batch {
+		batch[i].fields = map[string]interface{}{"n": i}
+	}
+	before := testutil.ToFloat64(sinkDropped.WithLabelValues("elasticsearch"))
+
+	e := &elasticsearchSink{host: "gpu-host"}
+	retry, err := e.post(batch)
+	if err == nil {
+		t.Fatal("post succeeded, want an error for the 429s")
+	}
+	if len(retry) != 2 || retry[0].fields["n"] != 1 || retry[1].fields["n"] != 3 {
+		t.Errorf("got retry %v, want records 1 and 3", retry)
+	}
+	if got := testutil.ToFloat64(sinkDropped.WithLabelValues("elasticsearch")) - before; got != 1 {
+		t.Errorf("dropped %v records, want 1", got)
+	}
+}
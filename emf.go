@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// CloudWatch Embedded Metric Format output. Each sample is written as a
+// single line of JSON, which the CloudWatch agent turns into metrics.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+
+var (
+	emfOutput     = flag.String("emf-output", "", "write CloudWatch EMF records to this file (\"-\" for stdout); disabled if empty")
+	emfNamespace  = flag.String("emf-namespace", "NvidiaExporter", "CloudWatch namespace for EMF records")
+	emfDimensions = flag.String("emf-dimensions", "instance,uuid,model", "comma separated EMF dimensions (any of instance, gpu, uuid, model)")
+	emfResolution = flag.Int("emf-resolution", 60, "CloudWatch storage resolution in seconds for EMF metrics (1 or 60)")
+)
+
+type emfSink struct {
+	out        io.Writer
+	instance   string
+	dimensions []string
+	metrics    []emfMetric
+}
+
+type emfMetric struct {
+	Name              string
+	Unit              string
+	StorageResolution int
+}
+
+func newEMFSink() (*emfSink, error) {
+	if *emfResolution != 1 && *emfResolution != 60 {
+		return nil, fmt.Errorf("resolution must be 1 or 60, not %d", *emfResolution)
+	}
+
+	e := &emfSink{}
+	for _, dim := range strings.Split(*emfDimensions, ",") {
+		dim = strings.TrimSpace(dim)
+		switch dim {
+		case "":
+			continue
+		case "instance", "gpu", "uuid", "model":
+			e.dimensions = append(e.dimensions, dim)
+		default:
+			return nil, fmt.Errorf("unknown dimension %q", dim)
+		}
+	}
+
+	for _, stat := range stats {
+		e.metrics = append(e.metrics, emfMetric{
+			Name:              stat.name,
+			Unit:              stat.unit,
+			StorageResolution: *emfResolution,
+		})
+	}
+
+	var err error
+	if e.instance, err = os.Hostname(); err != nil {
+		return nil, err
+	}
+
+	if *emfOutput == "-" {
+		e.out = os.Stdout
+	} else {
+		f, err := os.OpenFile(*emfOutput, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		e.out = f
+	}
+
+	log.Printf("Writing CloudWatch EMF records to %s", *emfOutput)
+	return e, nil
+}
+
+func (e *emfSink) write(sample gpuSample) {
+	record := map[string]interface{}{
+		"instance": e.instance,
+		"gpu":      sample.gpu,
+		"uuid":     sample.uuid,
+		"model":    sample.model,
+	}
+
+	// only declare the metrics this GPU reported
+	metrics := []emfMetric{}
+	for i, stat := range stats {
+		if !math.IsNaN(sample.values[i]) {
+			record[stat.name] = sample.values[i]
+			metrics = append(metrics, e.metrics[i])
+		}
+	}
+
+	record["_aws"] = map[string]interface{}{
+		"Timestamp": sample.time.UnixNano() / 1e6,
+		"CloudWatchMetrics": []interface{}{
+			map[string]interface{}{
+				"Namespace":  *emfNamespace,
+				"Dimensions": [][]string{e.dimensions},
+				"Metrics":    metrics,
+			},
+		},
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("error encoding EMF record: %s", err)
+		return
+	}
+	if _, err := e.out.Write(append(line, '\n')); err != nil {
+		log.Printf("error writing EMF record: %s", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setEMFFlags sets the EMF flags for one test, restoring them afterwards.
+func setEMFFlags(t *testing.T, output, dimensions string, resolution int) {
+	oldOutput, oldDimensions, oldResolution := *emfOutput, *emfDimensions, *emfResolution
+	t.Cleanup(func() { *emfOutput, *emfDimensions, *emfResolution = oldOutput, oldDimensions, oldResolution })
+	*emfOutput, *emfDimensions, *emfResolution = output, dimensions, resolution
+}
+
+func TestEMFRecord(t *testing.T) {
+	setEMFFlags(t, filepath.Join(t.TempDir(), "emf.log"), "instance, gpu", 1)
+	e, err := newEMFSink()
+	if err != nil {
+		t.Fatalf("newEMFSink: %s", err)
+	}
+	var buf bytes.Buffer
+	e.out = &buf
+
+	values := make([]float64, len(stats))
+	eccIndex := -1
+	for i, stat := range stats {
+		values[i] = float64(i + 1)
+		if stat.name == "ecc.errors.uncorrected.volatile.total" {
+			eccIndex = i
+			values[i] = math.NaN()
+		}
+	}
+	e.write(gpuSample{
+		time:   time.Unix(1700000000, 250000000),
+		gpu:    "0",
+		uuid:   "GPU-0",
+		model:  "NVIDIA H100",
+		values: values,
+	})
+
+	var record struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []emfMetric
+			}
+		} `json:"_aws"`
+	}
+	line := buf.Bytes()
+	if err := json.Unmarshal(line, &record); err != nil {
+		t.Fatalf("decoding %q: %s", line, err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		t.Fatalf("decoding %q: %s", line, err)
+	}
+
+	if record.AWS.Timestamp != 1700000000250 {
+		t.Errorf("got timestamp %d, want 1700000000250 (milliseconds)", record.AWS.Timestamp)
+	}
+	if len(record.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("got %d CloudWatchMetrics, want 1", len(record.AWS.CloudWatchMetrics))
+	}
+	cw := record.AWS.CloudWatchMetrics[0]
+	if cw.Namespace != "NvidiaExporter" {
+		t.Errorf("got namespace %q", cw.Namespace)
+	}
+	if want := [][]string{{"instance", "gpu"}}; !reflect.DeepEqual(cw.Dimensions, want) {
+		t.Errorf("got dimensions %v, want %v", cw.Dimensions, want)
+	}
+	if len(cw.Metrics) != len(stats)-1 {
+		t.Errorf("got %d metrics, want %d", len(cw.Metrics), len(stats)-1)
+	}
+	for _, m := range cw.Metrics {
+		if m.StorageResolution != 1 {
+			t.Errorf("%s has storage resolution %d, want 1", m.Name, m.StorageResolution)
+		}
+		if m.Name == stats[eccIndex].name {
+			t.Errorf("unsupported %s declared as a metric", m.Name)
+		}
+		if fields[m.Name] == nil {
+			t.Errorf("metric %s has no value in the record", m.Name)
+		}
+	}
+	if _, ok := fields[stats[eccIndex].name]; ok {
+		t.Errorf("unsupported %s has a value in the record", stats[eccIndex].name)
+	}
+	if fields["gpu"] != "0" || fields["uuid"] != "GPU-0" || fields["model"] != "NVIDIA H100" || fields["instance"] == "" {
+		t.Errorf("unexpected dimension values in %s", line)
+	}
+}
+
+func TestEMFInvalidFlags(t *testing.T) {
+	for _, tt := range []struct {
+		dimensions string
+		resolution int
+	}{
+		{"instance,hostname", 60},
+		{"instance,uuid,model", 30},
+	} {
+		setEMFFlags(t, filepath.Join(t.TempDir(), "emf.log"), tt.dimensions, tt.resolution)
+		if _, err := newEMFSink(); err == nil {
+			t.Errorf("dimensions %q and resolution %d: got no error", tt.dimensions, tt.resolution)
+		}
+	}
+}
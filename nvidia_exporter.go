@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
@@ -18,15 +20,40 @@ import (
 
 type nvidiaStat struct {
 	name   string
+	unit   string // CloudWatch unit, see emf.go
 	metric prometheus.GaugeVec
+
+	// optional stats are not reported by every GPU. When a GPU reports
+	// them as N/A their value is NaN and the gauge is not set.
+	optional bool
+}
+
+// gpuSample holds the stats for a single GPU from one line of
+// nvidia-smi output. values is in the same order as stats, with NaN for
+// unsupported optional stats.
+type gpuSample struct {
+	time     time.Time
+	gpu      string
+	uuid     string
+	model    string
+	pciBusID string
+	values   []float64
+}
+
+// A sink receives every sample read from nvidia-smi, in addition to the
+// prometheus gauges being updated.
+type sink interface {
+	write(sample gpuSample)
 }
 
 var (
 	interval = flag.Duration("interval", 5*time.Second, "how often to request stats from nvidia-smi")
 	port     = flag.Int("port", 9523, "http port to expose metrics on")
+	sinks    []sink
 	stats    = []nvidiaStat{
 		nvidiaStat{
 			name: "memory.used",
+			unit: "Megabytes",
 			metric: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nvidia_memory_used_megabytes",
 				Help: "Total memory allocated by active contexts",
@@ -34,6 +61,7 @@ var (
 		},
 		nvidiaStat{
 			name: "memory.total",
+			unit: "Megabytes",
 			metric: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nvidia_memory_total_megabytes",
 				Help: "Total installed GPU memory",
@@ -41,6 +69,7 @@ var (
 		},
 		nvidiaStat{
 			name: "utilization.gpu",
+			unit: "Percent",
 			metric: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nvidia_gpu_utilization_percent",
 				Help: "Percent of time over the past sample period during which one or more kernels was executing on the GPU",
@@ -48,6 +77,7 @@ var (
 		},
 		nvidiaStat{
 			name: "utilization.memory",
+			unit: "Percent",
 			metric: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nvidia_memory_utilization_percent",
 				Help: "Percent of time over the past sample period during which global (device) memory was being read or written",
@@ -55,6 +85,7 @@ var (
 		},
 		nvidiaStat{
 			name: "temperature.gpu",
+			unit: "None",
 			metric: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nvidia_temperature_celsius",
 				Help: "Core GPU temperature",
@@ -62,11 +93,21 @@ var (
 		},
 		nvidiaStat{
 			name: "power.draw",
+			unit: "None",
 			metric: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "nvidia_power_draw_watts",
 				Help: "The last measured power draw for the entire board",
 			}, []string{"gpu"}),
 		},
+		nvidiaStat{
+			name:     "ecc.errors.uncorrected.volatile.total",
+			unit:     "Count",
+			optional: true,
+			metric: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "nvidia_ecc_uncorrected_errors",
+				Help: "Uncorrected (double-bit) ECC errors since the driver was last loaded",
+			}, []string{"gpu"}),
+		},
 	}
 )
 
@@ -77,7 +118,7 @@ func scrapeSmi() {
 	})
 	prometheus.MustRegister(lastUpdated)
 
-	queryValues := []string{"index"}
+	queryValues := []string{"index", "uuid", "name", "pci.bus_id"}
 	for _, stat := range stats {
 		prometheus.MustRegister(stat.metric)
 		queryValues = append(queryValues, stat.name)
@@ -89,17 +130,30 @@ func scrapeSmi() {
 		log.Fatalf("interval must be at least 1 second")
 	}
 
+	// nvidia-smi is restarted if it exits, so that alerts can report it
+	// as stale rather than the exporter disappearing too.
+	for {
+		runSmiLoop(seconds, queryOpt, lastUpdated)
+		time.Sleep(*interval)
+	}
+}
+
+func runSmiLoop(seconds, queryOpt string, lastUpdated prometheus.Gauge) {
 	cmd := exec.Command("nvidia-smi", "-l", seconds, "--format=csv,noheader,nounits", queryOpt)
 	cmdStdout, _ := cmd.StdoutPipe()
 	cmdStdoutReader := bufio.NewReader(cmdStdout)
 
 	log.Printf("Running %s", strings.Join(cmd.Args, " "))
-	cmd.Start()
+	if err := cmd.Start(); err != nil {
+		log.Printf("error running nvidia-smi: %s", err)
+		return
+	}
 
 	for {
 		line, err := cmdStdoutReader.ReadBytes('\n')
 		if err != nil {
-			log.Fatalf("error reading nvidia-smi output: %s", err)
+			log.Printf("error reading nvidia-smi output: %s", err)
+			break
 		}
 		lastUpdated.Set(float64(time.Now().Unix()))
 
@@ -109,30 +163,120 @@ func scrapeSmi() {
 		// should ever contain a "," or need anything fancier.
 		data := strings.Split(string(line), ", ")
 
-		// We should have an output field for each stat plus the index
-		if len(data) != len(stats)+1 {
-			log.Fatalf("invalid nvidia-smi output: %s", line)
+		// We should have an output field for each stat plus the index,
+		// uuid, name and PCI bus id. Anything else, such as a lost GPU,
+		// is skipped so the GPU stops being reported.
+		if len(data) != len(stats)+4 {
+			log.Printf("invalid nvidia-smi output: %s", line)
+			continue
 		}
 
-		gpu := data[0]
+		sample := gpuSample{
+			time:     time.Now(),
+			gpu:      data[0],
+			uuid:     data[1],
+			model:    data[2],
+			pciBusID: data[3],
+			values:   make([]float64, len(stats)),
+		}
+		valid := true
 		for i, stat := range stats {
-			value, err := strconv.ParseFloat(data[i+1], 64)
+			value, err := strconv.ParseFloat(data[i+4], 64)
+			if err != nil && stat.optional && notSupported(data[i+4]) {
+				value, err = math.NaN(), nil
+			}
 			if err != nil {
-				log.Fatalf("error converting %s value (%s) to float: %s", stat.name, data[i+1], err)
+				log.Printf("error converting %s value (%s) to float for GPU %s: %s", stat.name, data[i+4], sample.gpu, err)
+				valid = false
+				break
+			}
+			sample.values[i] = value
+		}
+		if !valid {
+			continue
+		}
+
+		for i, stat := range stats {
+			if !math.IsNaN(sample.values[i]) {
+				stat.metric.With(prometheus.Labels{"gpu": sample.gpu}).Set(sample.values[i])
 			}
-			stat.metric.With(prometheus.Labels{"gpu": gpu}).Set(value)
+		}
+		for _, s := range sinks {
+			s.write(sample)
 		}
 	}
 
-	// If we end up here, something went horribly wrong with nvidia-smi
 	cmd.Wait()
-	os.Exit(1)
+}
+
+// notSupported reports whether an nvidia-smi value means the GPU does not
+// support the field, as opposed to a value that failed to read.
+func notSupported(value string) bool {
+	return value == "[N/A]" || value == "[Not Supported]"
+}
+
+// runSmi runs nvidia-smi with a timeout, so a hung driver does not hang
+// the caller too. Only stdout is returned, so warnings on stderr do not end
+// up in parsed output; see firstLine for reporting failures.
+func runSmi(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "nvidia-smi", args...)
+	// don't wait on children of nvidia-smi that still hold stdout open
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after 10s")
+	}
+	return strings.TrimSpace(string(out)), err
+}
+
+// firstLine describes a failed nvidia-smi run by the first line of its
+// output, or of its stderr, falling back to the error itself.
+func firstLine(out string, err error) string {
+	if out == "" {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			out = strings.TrimSpace(string(exitErr.Stderr))
+		}
+	}
+	if out != "" {
+		return strings.Split(out, "\n")[0]
+	}
+	return err.Error()
 }
 
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == "doctor" {
+		// Flags may also follow the subcommand, e.g. doctor -port 9000
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if flag.NArg() > 0 {
+			log.Fatalf("unexpected arguments after doctor: %s", strings.Join(flag.Args(), " "))
+		}
+		os.Exit(doctor())
+	}
+	if flag.NArg() > 0 {
+		log.Fatalf("unknown command: %s", flag.Arg(0))
+	}
+
+	setupSinks()
+
 	go scrapeSmi()
+	if *confCompute {
+		if *confComputeInterval <= 0 {
+			log.Fatalf("conf-compute-interval must be greater than 0")
+		}
+		go scrapeConfCompute()
+	}
+	if *settingsInterval > 0 {
+		if *settingsHistory < 0 {
+			log.Fatalf("settings-history must not be negative")
+		}
+		go trackSettings()
+		http.HandleFunc("/api/setting-changes", serveSettingChanges)
+	}
 
 	addr := fmt.Sprintf(":%d", *port)
 	http.Handle("/metrics", promhttp.Handler())
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestFirstLine(t *testing.T) {
+	_, exitErr := exec.Command("sh", "-c", "echo 'Failed to initialize NVML: Driver/library version mismatch' >&2; echo second >&2; exit 9").Output()
+	if _, ok := exitErr.(*exec.ExitError); !ok {
+		t.Fatalf("got %v, want an *exec.ExitError", exitErr)
+	}
+
+	for _, tt := range []struct {
+		name string
+		out  string
+		err  error
+		want string
+	}{
+		{"stdout", "No devices were found\nmore", errors.New("exit status 6"), "No devices were found"},
+		{"stderr", "", exitErr, "Failed to initialize NVML: Driver/library version mismatch"},
+		{"error", "", errors.New("timed out after 10s"), "timed out after 10s"},
+	} {
+		if got := firstLine(tt.out, tt.err); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Settings that scripts and people change by hand. They are polled
+// separately from the stats since most are not numbers.
+
+var (
+	settingsInterval = flag.Duration("settings-interval", 0, "how often to check GPU settings for changes, e.g. 1m; disabled if 0")
+	settingsHistory  = flag.Int("settings-history", 100, "number of recent setting changes to keep for /api/setting-changes")
+
+	settings = []string{
+		"power.limit",
+		"clocks.applications.graphics",
+		"clocks.applications.memory",
+		"compute_mode",
+		"persistence_mode",
+	}
+
+	settingChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nvidia_gpu_setting_changes_total",
+		Help: "Number of times a GPU setting was seen to change",
+	}, []string{"setting"})
+
+	recentChanges   []settingChange
+	recentChangesMu sync.Mutex
+)
+
+type settingChange struct {
+	Time    time.Time `json:"time"`
+	GPU     string    `json:"gpu"`
+	UUID    string    `json:"uuid"`
+	Setting string    `json:"setting"`
+	Old     string    `json:"old"`
+	New     string    `json:"new"`
+}
+
+// An eventSink is a sink that also receives setting changes.
+type eventSink interface {
+	writeChange(c settingChange)
+}
+
+func trackSettings() {
+	prometheus.MustRegister(settingChanges)
+	for _, setting := range settings {
+		settingChanges.WithLabelValues(setting)
+	}
+
+	queryOpt := fmt.Sprintf("--query-gpu=index,uuid,%s", strings.Join(settings, ","))
+
+	// last seen value of each setting, by GPU uuid
+	last := map[string][]string{}
+	for {
+		out, err := runSmi(queryOpt, "--format=csv,noheader,nounits")
+		if err != nil {
+			log.Printf("error reading GPU settings: %s", firstLine(out, err))
+		} else {
+			for _, c := range diffSettings(last, out, time.Now()) {
+				recordChange(c)
+			}
+		}
+		time.Sleep(*settingsInterval)
+	}
+}
+
+// diffSettings compares nvidia-smi settings output with the last seen
+// values, which it updates, and returns the settings that changed. A GPU
+// seen for the first time has no changes.
+func diffSettings(last map[string][]string, out string, now time.Time) []settingChange {
+	var changes []settingChange
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		data := strings.Split(line, ", ")
+		if len(data) != len(settings)+2 {
+			log.Printf("invalid nvidia-smi settings output: %s", line)
+			continue
+		}
+
+		gpu, uuid, values := data[0], data[1], data[2:]
+		if old, ok := last[uuid]; ok {
+			for i, setting := range settings {
+				if old[i] != values[i] {
+					changes = append(changes, settingChange{
+						Time:    now,
+						GPU:     gpu,
+						UUID:    uuid,
+						Setting: setting,
+						Old:     old[i],
+						New:     values[i],
+					})
+				}
+			}
+		}
+		last[uuid] = values
+	}
+	return changes
+}
+
+func recordChange(c settingChange) {
+	event, _ := json.Marshal(c)
+	log.Printf("GPU setting changed: %s", event)
+	settingChanges.WithLabelValues(c.Setting).Inc()
+
+	recentChangesMu.Lock()
+	recentChanges = append(recentChanges, c)
+	if len(recentChanges) > *settingsHistory {
+		recentChanges = recentChanges[len(recentChanges)-*settingsHistory:]
+	}
+	recentChangesMu.Unlock()
+
+	for _, s := range sinks {
+		if es, ok := s.(eventSink); ok {
+			es.writeChange(c)
+		}
+	}
+}
+
+// serveSettingChanges lists the recent setting changes as JSON, oldest
+// first.
+func serveSettingChanges(w http.ResponseWriter, r *http.Request) {
+	recentChangesMu.Lock()
+	changes := append([]settingChange{}, recentChanges...)
+	recentChangesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiffSettings(t *testing.T) {
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	last := map[string][]string{}
+
+	// first sighting of each GPU; malformed and empty lines are skipped
+	out := "0, GPU-0, 700.00, 1980, 2619, Default, Enabled\n" +
+		"1, GPU-1, 700.00, 1980, 2619, Default, Enabled\n" +
+		"2, GPU-2, [N/A]\n" +
+		"\n"
+	if changes := diffSettings(last, out, t0); changes != nil {
+		t.Errorf("got %v on first sighting, want no changes", changes)
+	}
+	if len(last) != 2 {
+		t.Errorf("remembered %d GPUs, want 2", len(last))
+	}
+
+	t1 := t0.Add(time.Minute)
+	out = "0, GPU-0, 500.00, 1980, 2619, Default, Enabled\n" +
+		"1, GPU-1, 700.00, 1980, 2619, Exclusive_Process, Enabled"
+	changes := diffSettings(last, out, t1)
+	if len(changes) != 2 {
+		t.Fatalf("got %v, want 2 changes", changes)
+	}
+	want := []settingChange{
+		{Time: t1, GPU: "0", UUID: "GPU-0", Setting: "power.limit", Old: "700.00", New: "500.00"},
+		{Time: t1, GPU: "1", UUID: "GPU-1", Setting: "compute_mode", Old: "Default", New: "Exclusive_Process"},
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("got %+v, want %+v", changes[i], want[i])
+		}
+	}
+
+	if changes := diffSettings(last, "", t1.Add(time.Minute)); changes != nil {
+		t.Errorf("got %v from empty output, want no changes", changes)
+	}
+	if len(last) != 2 {
+		t.Errorf("empty output changed the remembered GPUs to %v", last)
+	}
+}
+
+func TestSettingChangesHistory(t *testing.T) {
+	defer func(n int) { *settingsHistory = n }(*settingsHistory)
+	*settingsHistory = 2
+	defer func() { recentChanges = nil }()
+	recentChanges = nil
+
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for i, value := range []string{"300.00", "400.00", "500.00"} {
+		recordChange(settingChange{Time: t0.Add(time.Duration(i) * time.Minute), GPU: "0", UUID: "GPU-0", Setting: "power.limit", New: value})
+	}
+
+	w := httptest.NewRecorder()
+	serveSettingChanges(w, httptest.NewRequest("GET", "/api/setting-changes", nil))
+
+	var changes []settingChange
+	if err := json.NewDecoder(w.Body).Decode(&changes); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if len(changes) != 2 || changes[0].New != "400.00" || changes[1].New != "500.00" {
+		t.Errorf("got %+v, want the last 2 changes, oldest first", changes)
+	}
+}
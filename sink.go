@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sinkBatchSize     = flag.Int("sink-batch-size", 500, "maximum number of records per request to an HTTP sink")
+	sinkFlushInterval = flag.Duration("sink-flush-interval", 10*time.Second, "how often to flush partial batches to HTTP sinks")
+	sinkQueueSize     = flag.Int("sink-queue-size", 10000, "records to buffer per HTTP sink before dropping new ones")
+	sinkRetries       = flag.Int("sink-retries", 3, "how many times to retry a failed request to an HTTP sink")
+
+	sinkDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nvidia_sink_dropped_records_total",
+		Help: "Records that were never delivered to a sink, because its queue was full or requests kept failing",
+	}, []string{"sink"})
+	sinkFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nvidia_sink_failed_requests_total",
+		Help: "Requests to a sink that failed, including ones that were retried",
+	}, []string{"sink"})
+
+	sinkClient = &http.Client{Timeout: 30 * time.Second}
+
+	// sinkBackoff is the delay before the first retry; it doubles on
+	// each further one, up to sinkMaxBackoff.
+	sinkBackoff    = time.Second
+	sinkMaxBackoff = time.Minute
+)
+
+func setupSinks() {
+	prometheus.MustRegister(sinkDropped, sinkFailed)
+
+	if *sinkQueueSize < 0 {
+		log.Fatalf("sink-queue-size must not be negative")
+	}
+	if *sinkFlushInterval <= 0 {
+		log.Fatalf("sink-flush-interval must be greater than 0")
+	}
+	if *sinkBatchSize < 1 {
+		log.Fatalf("sink-batch-size must be at least 1")
+	}
+	if *sinkRetries < 0 {
+		log.Fatalf("sink-retries must not be negative")
+	}
+
+	if *emfOutput != "" {
+		s, err := newEMFSink()
+		if err != nil {
+			log.Fatalf("error setting up EMF output: %s", err)
+		}
+		sinks = append(sinks, s)
+	}
+	if *splunkURL != "" {
+		sinks = append(sinks, newSplunkSink())
+	}
+	if *esURL != "" {
+		sinks = append(sinks, newElasticsearchSink())
+	}
+	if *alertmanagerURLs != "" {
+		sinks = append(sinks, startAlerter())
+	}
+}
+
+// record is a single document queued for an HTTP sink.
+type record struct {
+	time   time.Time
+	kind   string
+	fields map[string]interface{}
+}
+
+func sampleRecord(sample gpuSample) record {
+	fields := map[string]interface{}{
+		"gpu":   sample.gpu,
+		"uuid":  sample.uuid,
+		"model": sample.model,
+	}
+	for i, stat := range stats {
+		if !math.IsNaN(sample.values[i]) {
+			fields[stat.name] = sample.values[i]
+		}
+	}
+	return record{time: sample.time, kind: "sample", fields: fields}
+}
+
+func changeRecord(c settingChange) record {
+	return record{
+		time: c.Time,
+		kind: "change",
+		fields: map[string]interface{}{
+			"gpu":     c.GPU,
+			"uuid":    c.UUID,
+			"setting": c.Setting,
+			"old":     c.Old,
+			"new":     c.New,
+		},
+	}
+}
+
+// A batcher queues records and posts them in batches from its own
+// goroutine, so a slow or unavailable endpoint never holds up reading
+// nvidia-smi. Once the queue is full new records are dropped.
+type batcher struct {
+	name  string
+	queue chan record
+
+	// post sends a batch. On failure it returns the records worth
+	// retrying, which is nil if retrying would not help.
+	post func(batch []record) ([]record, error)
+}
+
+func newBatcher(name string, post func([]record) ([]record, error)) *batcher {
+	b := &batcher{
+		name:  name,
+		queue: make(chan record, *sinkQueueSize),
+		post:  post,
+	}
+	go b.run()
+	return b
+}
+
+func (b *batcher) add(r record) {
+	select {
+	case b.queue <- r:
+	default:
+		sinkDropped.WithLabelValues(b.name).Inc()
+	}
+}
+
+func (b *batcher) run() {
+	ticker := time.NewTicker(*sinkFlushInterval)
+	var batch []record
+	for {
+		select {
+		case r := <-b.queue:
+			batch = append(batch, r)
+			if len(batch) < *sinkBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		b.flush(batch)
+		batch = nil
+	}
+}
+
+func (b *batcher) flush(batch []record) {
+	backoff := sinkBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := b.post(batch)
+		if err == nil {
+			return
+		}
+		sinkFailed.WithLabelValues(b.name).Inc()
+
+		if retry == nil || attempt >= *sinkRetries {
+			if retry != nil {
+				batch = retry
+			}
+			log.Printf("%s: dropping %d records: %s", b.name, len(batch), err)
+			sinkDropped.WithLabelValues(b.name).Add(float64(len(batch)))
+			return
+		}
+
+		log.Printf("%s: %s, retrying %d records in %s", b.name, err, len(retry), backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > sinkMaxBackoff {
+			backoff = sinkMaxBackoff
+		}
+		batch = retry
+	}
+}
+
+// httpStatusError is returned by sendHTTP for non-2xx responses.
+type httpStatusError struct {
+	status int
+	body   string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.status, e.body)
+}
+
+// retryable reports whether a request that failed with err might succeed
+// if sent again: network errors, throttling and server errors.
+func retryable(err error) bool {
+	if e, ok := err.(*httpStatusError); ok {
+		return e.status == http.StatusTooManyRequests || e.status >= 500
+	}
+	return true
+}
+
+// sendHTTP sends a request and returns the response body, or an error
+// if the request failed or did not return a 2xx status.
+func sendHTTP(req *http.Request) ([]byte, error) {
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &httpStatusError{status: resp.StatusCode, body: string(respBody)}
+	}
+	return respBody, nil
+}
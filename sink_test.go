@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testRecords(n int) []record {
+	var batch []record
+	for i := 0; i < n; i++ {
+		batch = append(batch, record{
+			time:   time.Unix(1700000000, 0),
+			kind:   "sample",
+			fields: map[string]interface{}{"gpu": "0", "temperature.gpu": 50.0},
+		})
+	}
+	return batch
+}
+
+// setFlag sets a string flag for one test, restoring it afterwards.
+func setFlag(t *testing.T, flag *string, value string) {
+	old := *flag
+	t.Cleanup(func() { *flag = old })
+	*flag = value
+}
+
+// statusServer answers requests with the given statuses in turn, repeating
+// the last one, and counts the requests it receives.
+func statusServer(t *testing.T, requests *int32, statuses ...int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(requests, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBatcherRetriesServerErrors(t *testing.T) {
+	defer func(d time.Duration) { sinkBackoff = d }(sinkBackoff)
+	sinkBackoff = 10 * time.Millisecond
+
+	var requests int32
+	srv := statusServer(t, &requests, http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK)
+	setFlag(t, splunkURL, srv.URL)
+
+	b := &batcher{name: "test-retry", post: (&splunkSink{host: "test"}).post}
+	start := time.Now()
+	b.flush(testRecords(2))
+
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("retries took %s, want at least 30ms of backoff", elapsed)
+	}
+	if got := testutil.ToFloat64(sinkDropped.WithLabelValues("test-retry")); got != 0 {
+		t.Errorf("dropped %v records, want 0", got)
+	}
+	if got := testutil.ToFloat64(sinkFailed.WithLabelValues("test-retry")); got != 2 {
+		t.Errorf("counted %v failed requests, want 2", got)
+	}
+}
+
+func TestBatcherGivesUpAfterRetries(t *testing.T) {
+	defer func(d time.Duration) { sinkBackoff = d }(sinkBackoff)
+	sinkBackoff = time.Millisecond
+
+	var requests int32
+	srv := statusServer(t, &requests, http.StatusBadGateway)
+	setFlag(t, splunkURL, srv.URL)
+
+	b := &batcher{name: "test-give-up", post: (&splunkSink{host: "test"}).post}
+	b.flush(testRecords(2))
+
+	if want := int32(*sinkRetries + 1); requests != want {
+		t.Errorf("got %d requests, want %d", requests, want)
+	}
+	if got := testutil.ToFloat64(sinkDropped.WithLabelValues("test-give-up")); got != 2 {
+		t.Errorf("dropped %v records, want 2", got)
+	}
+}
+
+func TestBatcherNegativeRetries(t *testing.T) {
+	defer func(n int) { *sinkRetries = n }(*sinkRetries)
+	*sinkRetries = -1
+
+	var requests int32
+	srv := statusServer(t, &requests, http.StatusBadGateway)
+	setFlag(t, splunkURL, srv.URL)
+
+	b := &batcher{name: "test-negative-retries", post: (&splunkSink{host: "test"}).post}
+	b.flush(testRecords(1))
+
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
+
+func TestBatcherDropsClientErrors(t *testing.T) {
+	var requests int32
+	srv := statusServer(t, &requests, http.StatusBadRequest)
+	setFlag(t, splunkURL, srv.URL)
+
+	b := &batcher{name: "test-client-error", post: (&splunkSink{host: "test"}).post}
+	b.flush(testRecords(3))
+
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+	if got := testutil.ToFloat64(sinkDropped.WithLabelValues("test-client-error")); got != 3 {
+		t.Errorf("dropped %v records, want 3", got)
+	}
+}
+
+func TestBatcherFullQueue(t *testing.T) {
+	b := &batcher{name: "test-full", queue: make(chan record, 2)}
+	for _, r := range testRecords(5) {
+		b.add(r)
+	}
+
+	if len(b.queue) != 2 {
+		t.Errorf("queued %d records, want 2", len(b.queue))
+	}
+	if got := testutil.ToFloat64(sinkDropped.WithLabelValues("test-full")); got != 3 {
+		t.Errorf("dropped %v records, want 3", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Splunk HTTP Event Collector output.
+// See https://docs.splunk.com/Documentation/Splunk/latest/Data/FormateventsforHTTPEventCollector
+
+var (
+	splunkURL        = flag.String("splunk-url", "", "Splunk HEC endpoint to send samples and setting changes to, e.g. https://splunk:8088/services/collector/event; disabled if empty")
+	splunkToken      = flag.String("splunk-token", "", "Splunk HEC token")
+	splunkIndex      = flag.String("splunk-index", "", "Splunk index to send to; uses the token's default index if empty")
+	splunkSourcetype = flag.String("splunk-sourcetype", "nvidia:gpu", "Splunk sourcetype prefix; the record kind is appended, e.g. nvidia:gpu:sample")
+)
+
+type splunkSink struct {
+	host    string
+	batcher *batcher
+}
+
+type splunkEvent struct {
+	Time       float64                `json:"time"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	Sourcetype string                 `json:"sourcetype"`
+	Index      string                 `json:"index,omitempty"`
+	Event      map[string]interface{} `json:"event"`
+}
+
+func newSplunkSink() *splunkSink {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("error getting hostname: %s", err)
+	}
+
+	s := &splunkSink{host: host}
+	s.batcher = newBatcher("splunk", s.post)
+	log.Printf("Sending samples and setting changes to Splunk HEC at %s", *splunkURL)
+	return s
+}
+
+func (s *splunkSink) write(sample gpuSample) {
+	s.batcher.add(sampleRecord(sample))
+}
+
+func (s *splunkSink) writeChange(c settingChange) {
+	s.batcher.add(changeRecord(c))
+}
+
+// post sends a batch as concatenated HEC events in a single request.
+// HEC accepts or rejects a request as a whole, so a failed batch is
+// retried in full.
+func (s *splunkSink) post(batch []record) ([]record, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range batch {
+		err := enc.Encode(splunkEvent{
+			Time:       float64(r.time.UnixNano()) / 1e9,
+			Host:       s.host,
+			Source:     "nvidia_exporter",
+			Sourcetype: *splunkSourcetype + ":" + r.kind,
+			Index:      *splunkIndex,
+			Event:      r.fields,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest("POST", *splunkURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Splunk "+*splunkToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	if _, err := sendHTTP(req); err != nil {
+		if retryable(err) {
+			return batch, err
+		}
+		return nil, err
+	}
+	return nil, nil
+}
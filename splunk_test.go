@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSplunkPost(t *testing.T) {
+	var auth string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	setFlag(t, splunkURL, srv.URL)
+	setFlag(t, splunkToken, "secret")
+	setFlag(t, splunkIndex, "gpus")
+
+	batch := []record{
+		{time: time.Unix(1700000000, 500000000), kind: "sample", fields: map[string]interface{}{"gpu": "0", "power.draw": 300.5}},
+		{time: time.Unix(1700000001, 0), kind: "change", fields: map[string]interface{}{"gpu": "1", "setting": "power.limit"}},
+	}
+	s := &splunkSink{host: "gpu-host"}
+	if _, err := s.post(batch); err != nil {
+		t.Fatalf("post: %s", err)
+	}
+
+	if auth != "Splunk secret" {
+		t.Errorf("got Authorization %q, want %q", auth, "Splunk secret")
+	}
+
+	var events []splunkEvent
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var e splunkEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("decoding %q: %s", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2:\n%s", len(events), body)
+	}
+
+	e := events[0]
+	if e.Time != 1700000000.5 || e.Host != "gpu-host" || e.Source != "nvidia_exporter" ||
+		e.Sourcetype != "nvidia:gpu:sample" || e.Index != "gpus" {
+		t.Errorf("unexpected event metadata: %+v", e)
+	}
+	if e.Event["gpu"] != "0" || e.Event["power.draw"] != 300.5 {
+		t.Errorf("unexpected event fields: %v", e.Event)
+	}
+	if events[1].Sourcetype != "nvidia:gpu:change" {
+		t.Errorf("got sourcetype %q, want nvidia:gpu:change", events[1].Sourcetype)
+	}
+}
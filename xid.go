@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Xid errors are reported by the driver only in the kernel log, e.g.
+// "NVRM: Xid (PCI:0000:3b:00): 79, pid=0, GPU has fallen off the bus."
+// See https://docs.nvidia.com/deploy/xid-errors/
+
+const kmsgPath = "/dev/kmsg"
+
+var xidPattern = regexp.MustCompile(`NVRM: Xid \((?:PCI:)?([0-9a-fA-F:.]+)\): (\d+),? *(.*)`)
+
+type xidEvent struct {
+	time     time.Time
+	pciBusID string // as normalized by normalizePCIBusID
+	code     int
+	message  string
+}
+
+// watchXids reads new kernel log records from /dev/kmsg and calls found
+// for each Xid error. Records logged before it starts are skipped, so old
+// Xids are not reported again when the exporter restarts.
+func watchXids(found func(xidEvent)) error {
+	f, err := os.Open(kmsgPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+
+	go func() {
+		defer f.Close()
+		// each read returns a single record, which is at most about 8k
+		buf := make([]byte, 8192)
+		for {
+			n, err := f.Read(buf)
+			if errors.Is(err, syscall.EPIPE) {
+				// older records were overwritten before we read them
+				continue
+			}
+			if err != nil {
+				log.Printf("error reading %s, no longer watching for Xid errors: %s", kmsgPath, err)
+				return
+			}
+			if x, ok := parseXid(string(buf[:n]), time.Now()); ok {
+				found(x)
+			}
+		}
+	}()
+	return nil
+}
+
+// parseXid parses a /dev/kmsg record such as
+// "3,1234,5678901,-;NVRM: Xid (PCI:0000:3b:00): 79, pid=0, GPU has fallen off the bus."
+// It reports false if the record is not an Xid error.
+func parseXid(record string, now time.Time) (xidEvent, bool) {
+	// the message follows the record's metadata, and is followed by
+	// continuation lines with extra key=value pairs
+	if i := strings.Index(record, ";"); i >= 0 {
+		record = record[i+1:]
+	}
+	record = strings.SplitN(record, "\n", 2)[0]
+
+	m := xidPattern.FindStringSubmatch(record)
+	if m == nil {
+		return xidEvent{}, false
+	}
+	code, err := strconv.Atoi(m[2])
+	if err != nil {
+		return xidEvent{}, false
+	}
+	return xidEvent{
+		time:     now,
+		pciBusID: normalizePCIBusID(m[1]),
+		code:     code,
+		message:  strings.TrimSpace(m[3]),
+	}, true
+}
+
+// normalizePCIBusID turns the PCI bus ids printed by nvidia-smi, such as
+// "00000000:3B:00.0", and by the driver's Xid messages, such as
+// "0000:3b:00", into the same form: "0000:3b:00".
+func normalizePCIBusID(id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if i := strings.LastIndex(id, "."); i >= 0 {
+		id = id[:i]
+	}
+	parts := strings.Split(id, ":")
+	switch len(parts) {
+	case 2:
+		return "0000:" + id
+	case 3:
+		if domain, err := strconv.ParseUint(parts[0], 16, 32); err == nil {
+			return fmt.Sprintf("%04x:%s:%s", domain, parts[1], parts[2])
+		}
+	}
+	return id
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseXid(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		record string
+		ok     bool
+		want   xidEvent
+	}{
+		{
+			"3,1234,5678901,-;NVRM: Xid (PCI:0000:3b:00): 79, pid=0, GPU has fallen off the bus.\n SUBSYSTEM=pci\n",
+			true,
+			xidEvent{time: now, pciBusID: "0000:3b:00", code: 79, message: "pid=0, GPU has fallen off the bus."},
+		},
+		{
+			"4,99,100,-;NVRM: Xid (0000:03:00): 13, Graphics Exception",
+			true,
+			xidEvent{time: now, pciBusID: "0000:03:00", code: 13, message: "Graphics Exception"},
+		},
+		{"6,100,200,-;NVRM: loading NVIDIA UNIX x86_64 Kernel Module  550.54.15", false, xidEvent{}},
+		{"6,101,300,-;usb 1-1: new high-speed USB device number 2", false, xidEvent{}},
+	} {
+		got, ok := parseXid(tt.record, now)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseXid(%q) = %+v, %v; want %+v, %v", tt.record, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalizePCIBusID(t *testing.T) {
+	for _, tt := range []struct {
+		id   string
+		want string
+	}{
+		{"00000000:3B:00.0", "0000:3b:00"},
+		{"0000:3b:00", "0000:3b:00"},
+		{"00000001:04:00.0", "0001:04:00"},
+		{"3b:00", "0000:3b:00"},
+	} {
+		if got := normalizePCIBusID(tt.id); got != tt.want {
+			t.Errorf("normalizePCIBusID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}